@@ -0,0 +1,508 @@
+// Package aferofs exposes a wiring.Bucket as an afero.Fs, so in-process Go
+// programs can use the same file/directory semantics gcsfuse presents over
+// FUSE without mounting anything. Layering aferofs on top of wiring.Bucket
+// (rather than duplicating bucket-decorator logic) is what lets it pick up
+// --encrypt, --pointer-threshold, and any other Bucket decorator
+// automatically: whatever Bucket cmd/gcsfuse assembles for the mounted
+// mode is the same one an aferofs.Fs wraps.
+//
+// Directories are represented either implicitly (the way wiring.FakeBucket
+// documents for "bar"), as any object name that is a strict prefix of
+// another object's name up to a "/", or explicitly via a zero-byte marker object
+// whose name ends in "/" (so an otherwise-empty directory created with
+// Mkdir still exists after its last file is removed).
+package aferofs
+
+import (
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/wiring"
+	"github.com/spf13/afero"
+)
+
+// Fs adapts a wiring.Bucket to afero.Fs.
+type Fs struct {
+	bucket wiring.Bucket
+}
+
+var _ afero.Fs = &Fs{}
+
+// New returns an afero.Fs backed by bucket.
+func New(bucket wiring.Bucket) *Fs {
+	return &Fs{bucket: bucket}
+}
+
+func (fs *Fs) Name() string { return "aferofs:" + fs.bucket.Name() }
+
+func clean(name string) string {
+	return strings.Trim(name, "/")
+}
+
+// splitFirstComponent splits rel (relative to some directory) into its
+// first path component and whether that component is itself a directory
+// (i.e. rel has more path left after it).
+func splitFirstComponent(rel string) (component string, isDir bool) {
+	if idx := strings.IndexByte(rel, '/'); idx != -1 {
+		return rel[:idx], true
+	}
+	return rel, false
+}
+
+// isDir reports whether name (already cleaned) names a directory: the
+// root, an explicit marker object, or an implicit prefix of some object.
+func (fs *Fs) isDir(name string) bool {
+	if name == "" {
+		return true
+	}
+	if _, err := fs.bucket.Object(name + "/"); err == nil {
+		return true
+	}
+
+	names, err := fs.bucket.ListObjects(name + "/")
+	return err == nil && len(names) > 0
+}
+
+func notExist(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+func (fs *Fs) Stat(name string) (os.FileInfo, error) {
+	name = clean(name)
+
+	if raw, err := fs.bucket.Object(name); err == nil {
+		return newFileInfo(baseName(name), int64(len(raw)), false), nil
+	} else if !wiring.IsNotExist(err) {
+		return nil, err
+	}
+
+	if fs.isDir(name) {
+		return newFileInfo(baseName(name), 0, true), nil
+	}
+
+	return nil, notExist("stat", name)
+}
+
+func baseName(name string) string {
+	if name == "" {
+		return "/"
+	}
+	if idx := strings.LastIndexByte(name, '/'); idx != -1 {
+		return name[idx+1:]
+	}
+	return name
+}
+
+// readDirEntries lists the immediate children of dir (cleaned), merging
+// file and (implicit or explicit) directory entries.
+func (fs *Fs) readDirEntries(dir string) ([]os.FileInfo, error) {
+	prefix := dir
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	names, err := fs.bucket.ListObjects(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	order := []string{}
+	entries := map[string]os.FileInfo{}
+	for _, n := range names {
+		rel := strings.TrimPrefix(n, prefix)
+		if rel == "" {
+			// The directory's own marker object.
+			continue
+		}
+
+		child, childIsDir := splitFirstComponent(rel)
+		if _, ok := entries[child]; ok {
+			continue
+		}
+
+		if childIsDir {
+			entries[child] = newFileInfo(child, 0, true)
+		} else {
+			raw, err := fs.bucket.Object(prefix + child)
+			if err != nil {
+				return nil, err
+			}
+			entries[child] = newFileInfo(child, int64(len(raw)), false)
+		}
+		order = append(order, child)
+	}
+
+	out := make([]os.FileInfo, len(order))
+	for i, name := range order {
+		out[i] = entries[name]
+	}
+	return out, nil
+}
+
+func (fs *Fs) Open(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (fs *Fs) OpenFile(name string, flag int, _ os.FileMode) (afero.File, error) {
+	name = clean(name)
+
+	if fs.isDir(name) {
+		entries, err := fs.readDirEntries(name)
+		if err != nil {
+			return nil, err
+		}
+		return &file{fs: fs, name: name, isDir: true, entries: entries}, nil
+	}
+
+	truncated := false
+	raw, err := fs.bucket.Object(name)
+	switch {
+	case err == nil:
+		if flag&os.O_TRUNC != 0 && len(raw) > 0 {
+			raw = nil
+			truncated = true
+		}
+	case wiring.IsNotExist(err):
+		if flag&os.O_CREATE == 0 {
+			return nil, notExist("open", name)
+		}
+		// Create the (empty) object immediately, the same way os.Create
+		// leaves a zero-byte file on disk before any Write call, so a
+		// sibling Readdir sees it even if the caller closes without
+		// writing.
+		if err := fs.bucket.CreateObject(name, nil); err != nil {
+			return nil, err
+		}
+		raw = nil
+	default:
+		return nil, err
+	}
+
+	f := &file{
+		fs:       fs,
+		name:     name,
+		contents: append([]byte{}, raw...),
+		writable: flag&(os.O_WRONLY|os.O_RDWR) != 0,
+		// O_TRUNC against an existing non-empty object truncated it in
+		// memory above; mark the file dirty so Close persists that
+		// truncation even if the caller never calls Write (the standard
+		// truncate-via-Create idiom, e.g. afero.WriteFile).
+		dirty: truncated,
+	}
+	if flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.contents))
+	}
+	return f, nil
+}
+
+func (fs *Fs) Create(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+func (fs *Fs) Mkdir(name string, _ os.FileMode) error {
+	name = clean(name)
+	if fs.isDir(name) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	return fs.bucket.CreateObject(name+"/", nil)
+}
+
+func (fs *Fs) MkdirAll(path string, perm os.FileMode) error {
+	name := clean(path)
+	if name == "" {
+		return nil
+	}
+
+	cur := ""
+	for _, part := range strings.Split(name, "/") {
+		if cur == "" {
+			cur = part
+		} else {
+			cur = cur + "/" + part
+		}
+		if !fs.isDir(cur) {
+			if err := fs.bucket.CreateObject(cur+"/", nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (fs *Fs) Remove(name string) error {
+	name = clean(name)
+
+	if fs.isDir(name) {
+		entries, err := fs.readDirEntries(name)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: os.ErrInvalid}
+		}
+		return fs.bucket.DeleteObject(name + "/")
+	}
+
+	return fs.bucket.DeleteObject(name)
+}
+
+func (fs *Fs) RemoveAll(path string) error {
+	name := clean(path)
+
+	if !fs.isDir(name) {
+		return fs.bucket.DeleteObject(name)
+	}
+
+	prefix := name
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	names, err := fs.bucket.ListObjects(prefix)
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		if err := fs.bucket.DeleteObject(n); err != nil {
+			return err
+		}
+	}
+	return fs.bucket.DeleteObject(name + "/")
+}
+
+func (fs *Fs) Rename(oldname, newname string) error {
+	oldname, newname = clean(oldname), clean(newname)
+
+	if !fs.isDir(oldname) {
+		raw, err := fs.bucket.Object(oldname)
+		if err != nil {
+			return err
+		}
+		if err := fs.bucket.CreateObject(newname, raw); err != nil {
+			return err
+		}
+		return fs.bucket.DeleteObject(oldname)
+	}
+
+	oldPrefix := oldname + "/"
+	names, err := fs.bucket.ListObjects(oldPrefix)
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		raw, err := fs.bucket.Object(n)
+		if err != nil {
+			return err
+		}
+		if err := fs.bucket.CreateObject(newname+"/"+strings.TrimPrefix(n, oldPrefix), raw); err != nil {
+			return err
+		}
+		if err := fs.bucket.DeleteObject(n); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fs.bucket.Object(oldPrefix); err == nil {
+		if err := fs.bucket.CreateObject(newname+"/", nil); err != nil {
+			return err
+		}
+		return fs.bucket.DeleteObject(oldPrefix)
+	}
+
+	return nil
+}
+
+// Chmod, Chown, and Chtimes are no-ops: wiring.Bucket doesn't model mode,
+// ownership, or timestamps, so every entry reports the fixed mode
+// fileInfo.Mode returns and a zero ModTime.
+func (fs *Fs) Chmod(name string, mode os.FileMode) error         { return nil }
+func (fs *Fs) Chown(name string, uid, gid int) error             { return nil }
+func (fs *Fs) Chtimes(name string, atime, mtime time.Time) error { return nil }
+
+type fileInfo struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+func newFileInfo(name string, size int64, isDir bool) *fileInfo {
+	return &fileInfo{name: name, size: size, isDir: isDir}
+}
+
+func (fi *fileInfo) Name() string { return fi.name }
+func (fi *fileInfo) Size() int64  { return fi.size }
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+func (fi *fileInfo) ModTime() time.Time { return time.Time{} }
+func (fi *fileInfo) IsDir() bool        { return fi.isDir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+// file implements afero.File on top of Fs, buffering the whole object in
+// memory -- adequate for the small objects wiring.Bucket deals in, the
+// same way wiring.memBucket itself keeps everything in memory.
+type file struct {
+	fs   *Fs
+	name string
+
+	// Regular file state.
+	contents []byte
+	offset   int64
+	writable bool
+	dirty    bool
+	closed   bool
+
+	// Directory state.
+	isDir   bool
+	entries []os.FileInfo
+	dirPos  int
+}
+
+var _ afero.File = &file{}
+
+func (f *file) Name() string { return "/" + f.name }
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.isDir {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrInvalid}
+	}
+	if f.offset >= int64(len(f.contents)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.contents[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(f.contents)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.contents[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		f.offset = offset
+	case io.SeekCurrent:
+		f.offset += offset
+	case io.SeekEnd:
+		f.offset = int64(len(f.contents)) + offset
+	}
+	return f.offset, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if !f.writable {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+	}
+
+	end := f.offset + int64(len(p))
+	if end > int64(len(f.contents)) {
+		grown := make([]byte, end)
+		copy(grown, f.contents)
+		f.contents = grown
+	}
+	copy(f.contents[f.offset:end], p)
+	f.offset = end
+	f.dirty = true
+	return len(p), nil
+}
+
+func (f *file) WriteAt(p []byte, off int64) (int, error) {
+	if !f.writable {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+	}
+
+	end := off + int64(len(p))
+	if end > int64(len(f.contents)) {
+		grown := make([]byte, end)
+		copy(grown, f.contents)
+		f.contents = grown
+	}
+	copy(f.contents[off:end], p)
+	f.dirty = true
+	return len(p), nil
+}
+
+func (f *file) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *file) Truncate(size int64) error {
+	if !f.writable {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: os.ErrPermission}
+	}
+
+	if size <= int64(len(f.contents)) {
+		f.contents = f.contents[:size]
+	} else {
+		grown := make([]byte, size)
+		copy(grown, f.contents)
+		f.contents = grown
+	}
+	f.dirty = true
+	return nil
+}
+
+func (f *file) Sync() error { return nil }
+
+func (f *file) Stat() (os.FileInfo, error) {
+	if f.isDir {
+		return newFileInfo(baseName(f.name), 0, true), nil
+	}
+	return newFileInfo(baseName(f.name), int64(len(f.contents)), false), nil
+}
+
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.isDir {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: os.ErrInvalid}
+	}
+
+	remaining := f.entries[f.dirPos:]
+	if count <= 0 {
+		f.dirPos = len(f.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if len(remaining) > count {
+		remaining = remaining[:count]
+	}
+	f.dirPos += len(remaining)
+	return remaining, nil
+}
+
+func (f *file) Readdirnames(n int) ([]string, error) {
+	entries, err := f.Readdir(n)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name()
+	}
+	return names, nil
+}
+
+func (f *file) Close() error {
+	if f.closed || f.isDir || !f.dirty {
+		f.closed = true
+		return nil
+	}
+
+	f.closed = true
+	return f.fs.bucket.CreateObject(f.name, f.contents)
+}
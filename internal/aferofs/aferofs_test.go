@@ -0,0 +1,214 @@
+package aferofs
+
+import (
+	"io"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/wiring"
+	"github.com/spf13/afero"
+)
+
+func TestCreateWriteCloseThenOpenReadsItBack(t *testing.T) {
+	fs := New(wiring.NewBucket("b"))
+
+	f, err := fs.Create("foo.txt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := f.WriteString("taco"); err != nil {
+		t.Fatalf("WriteString: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	f, err = fs.Open("foo.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != "taco" {
+		t.Fatalf("read %q, want %q", got, "taco")
+	}
+}
+
+func TestCreateOnExistingFileTruncatesEvenWithoutAWrite(t *testing.T) {
+	fs := New(wiring.NewBucket("b"))
+
+	f, err := fs.Create("foo.txt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := f.WriteString("taco"); err != nil {
+		t.Fatalf("WriteString: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// The standard truncate-to-empty idiom: re-Create an existing,
+	// non-empty file and Close it without ever calling Write.
+	f, err = fs.Create("foo.txt")
+	if err != nil {
+		t.Fatalf("Create (truncate): %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close (truncate): %v", err)
+	}
+
+	fi, err := fs.Stat("foo.txt")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if fi.Size() != 0 {
+		t.Fatalf("Stat(foo.txt).Size() = %d after truncating Create+Close, want 0", fi.Size())
+	}
+}
+
+func TestStatDistinguishesFilesAndImplicitDirectories(t *testing.T) {
+	bucket := wiring.NewBucket("b")
+	if err := bucket.CreateObject("bar/qux", []byte("queso")); err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+	fs := New(bucket)
+
+	fi, err := fs.Stat("bar/qux")
+	if err != nil {
+		t.Fatalf("Stat(bar/qux): %v", err)
+	}
+	if fi.IsDir() || fi.Size() != 5 {
+		t.Fatalf("Stat(bar/qux) = %+v, want a 5-byte file", fi)
+	}
+
+	fi, err = fs.Stat("bar")
+	if err != nil {
+		t.Fatalf("Stat(bar): %v", err)
+	}
+	if !fi.IsDir() {
+		t.Fatalf("Stat(bar) = %+v, want an implicit directory", fi)
+	}
+
+	if _, err := fs.Stat("nonexistent"); !os.IsNotExist(err) {
+		t.Fatalf("Stat(nonexistent) err = %v, want os.IsNotExist", err)
+	}
+}
+
+func TestMkdirThenReaddirListsChildren(t *testing.T) {
+	fs := New(wiring.NewBucket("b"))
+
+	if err := fs.Mkdir("dir", 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	for _, name := range []string{"dir/a", "dir/b"} {
+		f, err := fs.Create(name)
+		if err != nil {
+			t.Fatalf("Create(%q): %v", name, err)
+		}
+		f.Close()
+	}
+	if err := fs.Mkdir("dir/sub", 0755); err != nil {
+		t.Fatalf("Mkdir(dir/sub): %v", err)
+	}
+
+	f, err := fs.Open("dir")
+	if err != nil {
+		t.Fatalf("Open(dir): %v", err)
+	}
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		t.Fatalf("Readdirnames: %v", err)
+	}
+	sort.Strings(names)
+
+	want := []string{"a", "b", "sub"}
+	if len(names) != len(want) {
+		t.Fatalf("Readdirnames(dir) = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("Readdirnames(dir) = %v, want %v", names, want)
+		}
+	}
+}
+
+func TestRenameMovesAFileAndADirectoryTree(t *testing.T) {
+	fs := New(wiring.NewBucket("b"))
+
+	f, err := fs.Create("old.txt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	f.WriteString("taco")
+	f.Close()
+
+	if err := fs.Rename("old.txt", "new.txt"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if _, err := fs.Stat("old.txt"); !os.IsNotExist(err) {
+		t.Fatalf("old.txt still exists after Rename")
+	}
+	if fi, err := fs.Stat("new.txt"); err != nil || fi.Size() != 4 {
+		t.Fatalf("Stat(new.txt) = %+v, %v", fi, err)
+	}
+
+	if err := fs.Mkdir("dir", 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	f, _ = fs.Create("dir/child.txt")
+	f.WriteString("queso")
+	f.Close()
+
+	if err := fs.Rename("dir", "moved"); err != nil {
+		t.Fatalf("Rename(dir, moved): %v", err)
+	}
+	if _, err := fs.Stat("dir"); !os.IsNotExist(err) {
+		t.Fatalf("dir still exists after renaming it away")
+	}
+	if got, err := fs.Stat("moved/child.txt"); err != nil || got.Size() != 5 {
+		t.Fatalf("Stat(moved/child.txt) = %+v, %v", got, err)
+	}
+}
+
+func TestRemoveDeletesAFileAndRejectsNonEmptyDirectories(t *testing.T) {
+	fs := New(wiring.NewBucket("b"))
+
+	f, err := fs.Create("foo.txt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	f.Close()
+
+	if err := fs.Remove("foo.txt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := fs.Stat("foo.txt"); !os.IsNotExist(err) {
+		t.Fatalf("foo.txt still exists after Remove")
+	}
+
+	if err := fs.Mkdir("dir", 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	f, _ = fs.Create("dir/child.txt")
+	f.Close()
+
+	if err := fs.Remove("dir"); err == nil {
+		t.Fatalf("Remove(dir) on a non-empty directory should have failed")
+	}
+	if err := fs.Remove("dir/child.txt"); err != nil {
+		t.Fatalf("Remove(dir/child.txt): %v", err)
+	}
+	if err := fs.Remove("dir"); err != nil {
+		t.Fatalf("Remove(dir) after emptying it: %v", err)
+	}
+}
+
+var _ afero.Fs = &Fs{}
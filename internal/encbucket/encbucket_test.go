@@ -0,0 +1,200 @@
+package encbucket
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/wiring"
+)
+
+func TestRoundTripHidesPlaintext(t *testing.T) {
+	inner := wiring.NewBucket("raw")
+
+	b, err := Init(inner, []byte("hunter2"), 4)
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	const plaintext = "the quick brown fox"
+	if err := b.CreateObject("secret.txt", []byte(plaintext)); err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	// Nothing in the underlying bucket should contain the plaintext name or
+	// contents.
+	rawNames, err := inner.ListObjects("")
+	if err != nil {
+		t.Fatalf("inner.ListObjects: %v", err)
+	}
+	for _, n := range rawNames {
+		if n == "secret.txt" {
+			t.Fatalf("raw bucket has a plaintext object name %q", n)
+		}
+
+		raw, err := inner.Object(n)
+		if err != nil {
+			t.Fatalf("inner.Object(%q): %v", n, err)
+		}
+		if bytes.Contains(raw, []byte(plaintext)) {
+			t.Fatalf("raw bucket object %q contains the plaintext content", n)
+		}
+	}
+
+	got, err := b.Object("secret.txt")
+	if err != nil {
+		t.Fatalf("Object: %v", err)
+	}
+	if string(got) != plaintext {
+		t.Fatalf("Object = %q, want %q", got, plaintext)
+	}
+}
+
+func TestRemountWithCorrectPassphraseReadsBackContent(t *testing.T) {
+	inner := wiring.NewBucket("raw")
+
+	b1, err := Init(inner, []byte("hunter2"), 4)
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if err := b1.CreateObject("foo", []byte("taco")); err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	// Simulate a remount: open a fresh Bucket value against the same
+	// underlying (now-persisted) bucket.
+	b2, err := Open(inner, []byte("hunter2"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	got, err := b2.Object("foo")
+	if err != nil {
+		t.Fatalf("Object after remount: %v", err)
+	}
+	if string(got) != "taco" {
+		t.Fatalf("Object after remount = %q, want %q", got, "taco")
+	}
+}
+
+func TestOpenWithWrongPassphraseFails(t *testing.T) {
+	inner := wiring.NewBucket("raw")
+
+	if _, err := Init(inner, []byte("hunter2"), 4); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	if _, err := Open(inner, []byte("wrong")); err == nil {
+		t.Fatalf("Open with wrong passphrase unexpectedly succeeded")
+	}
+}
+
+func TestSetupInitializesThenReopens(t *testing.T) {
+	inner := wiring.NewBucket("raw")
+
+	b1, err := Setup(inner, []byte("hunter2"), 4)
+	if err != nil {
+		t.Fatalf("Setup (init): %v", err)
+	}
+	if err := b1.CreateObject("foo", []byte("taco")); err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	b2, err := Setup(inner, []byte("hunter2"), 4)
+	if err != nil {
+		t.Fatalf("Setup (reopen): %v", err)
+	}
+	got, err := b2.Object("foo")
+	if err != nil {
+		t.Fatalf("Object after Setup reopen: %v", err)
+	}
+	if string(got) != "taco" {
+		t.Fatalf("Object after Setup reopen = %q, want %q", got, "taco")
+	}
+
+	if _, err := Setup(inner, []byte("wrong"), 4); err == nil {
+		t.Fatalf("Setup with wrong passphrase against existing config unexpectedly succeeded")
+	}
+}
+
+func TestNameEncryptionPreservesDirectoryStructure(t *testing.T) {
+	inner := wiring.NewBucket("raw")
+
+	b, err := Init(inner, []byte("hunter2"), 4)
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	for _, name := range []string{"bar/qux", "bar/other", "baz"} {
+		if err := b.CreateObject(name, []byte(name)); err != nil {
+			t.Fatalf("CreateObject(%q): %v", name, err)
+		}
+	}
+
+	// Each path component should be encrypted independently, so the
+	// ciphertext names retain the plaintext's "/" structure instead of
+	// collapsing it into one opaque blob.
+	rawNames, err := inner.ListObjects("")
+	if err != nil {
+		t.Fatalf("inner.ListObjects: %v", err)
+	}
+	var sawTwoComponents int
+	for _, n := range rawNames {
+		if n == ConfigObjectName {
+			continue
+		}
+		if strings.Count(n, "/") == 1 {
+			sawTwoComponents++
+		}
+	}
+	if sawTwoComponents != 2 {
+		t.Fatalf("expected 2 raw names with a preserved 2-component path, got %d (names: %v)", sawTwoComponents, rawNames)
+	}
+
+	// Listing by directory prefix should only return that directory's
+	// contents, scoped by a real ciphertext prefix query rather than a
+	// full-bucket scan.
+	names, err := b.ListObjects("bar/")
+	if err != nil {
+		t.Fatalf("ListObjects(bar/): %v", err)
+	}
+	want := map[string]bool{"bar/qux": true, "bar/other": true}
+	if len(names) != len(want) {
+		t.Fatalf("ListObjects(bar/) = %v, want keys of %v", names, want)
+	}
+	for _, n := range names {
+		if !want[n] {
+			t.Fatalf("unexpected name %q in bar/ listing", n)
+		}
+	}
+}
+
+func TestListObjectsDecryptsNames(t *testing.T) {
+	inner := wiring.NewBucket("raw")
+
+	b, err := Init(inner, []byte("hunter2"), 4)
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	for _, name := range []string{"a", "b", "dir-ish/c"} {
+		if err := b.CreateObject(name, []byte(name)); err != nil {
+			t.Fatalf("CreateObject(%q): %v", name, err)
+		}
+	}
+
+	names, err := b.ListObjects("")
+	if err != nil {
+		t.Fatalf("ListObjects: %v", err)
+	}
+
+	want := map[string]bool{"a": true, "b": true, "dir-ish/c": true}
+	if len(names) != len(want) {
+		t.Fatalf("ListObjects = %v, want keys of %v", names, want)
+	}
+	for _, n := range names {
+		if !want[n] {
+			t.Fatalf("unexpected name %q in listing", n)
+		}
+	}
+}
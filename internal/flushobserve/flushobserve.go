@@ -0,0 +1,191 @@
+// Package flushobserve implements the binary-side support for the
+// FLUSH_PIPE/FSYNC_PIPE test hooks and the --flush-error/--fsync-error
+// fault-injection flags: a structured record (inode, path, length) is
+// written to the appropriate pipe every time the file system's Flush or
+// Fsync op runs, mirroring the fsutil.AnonymousFile + MountFiles pattern
+// jacobsa/fuse's own flushfs sample tests use to observe dirty-buffer
+// flush semantics without polling GCS.
+package flushobserve
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Op identifies which FUSE file system op a Record describes.
+type Op int
+
+const (
+	Flush Op = iota
+	Fsync
+)
+
+func (op Op) String() string {
+	if op == Fsync {
+		return "fsync"
+	}
+	return "flush"
+}
+
+// Record is one line written to a FLUSH_PIPE or FSYNC_PIPE: which op ran,
+// against which inode and path, and the file's length at the time.
+type Record struct {
+	Op     Op
+	Inode  uint64
+	Path   string
+	Length int64
+}
+
+// Marshal serializes r as a single newline-terminated line, e.g.
+// "flush inode=2 path=/foo length=4\n".
+func (r Record) Marshal() []byte {
+	return []byte(fmt.Sprintf("%s inode=%d path=%s length=%d\n", r.Op, r.Inode, r.Path, r.Length))
+}
+
+// ParseRecord parses a line written by Record.Marshal.
+func ParseRecord(line string) (Record, error) {
+	line = strings.TrimSuffix(line, "\n")
+	fields := strings.Fields(line)
+	if len(fields) != 4 {
+		return Record{}, fmt.Errorf("flushobserve: malformed record %q", line)
+	}
+
+	var r Record
+	switch fields[0] {
+	case "flush":
+		r.Op = Flush
+	case "fsync":
+		r.Op = Fsync
+	default:
+		return Record{}, fmt.Errorf("flushobserve: unknown op %q", fields[0])
+	}
+
+	for _, f := range fields[1:] {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			return Record{}, fmt.Errorf("flushobserve: malformed field %q", f)
+		}
+
+		switch kv[0] {
+		case "inode":
+			v, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				return Record{}, fmt.Errorf("flushobserve: inode: %w", err)
+			}
+			r.Inode = v
+		case "path":
+			r.Path = kv[1]
+		case "length":
+			v, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil {
+				return Record{}, fmt.Errorf("flushobserve: length: %w", err)
+			}
+			r.Length = v
+		default:
+			return Record{}, fmt.Errorf("flushobserve: unknown field %q", kv[0])
+		}
+	}
+
+	return r, nil
+}
+
+// FaultInjector decides whether a Flush or Fsync against a given path
+// should fail, per the --flush-error/--fsync-error flags. A spec is either
+// empty (never fail), "*" (always fail), or a comma-separated list of
+// exact paths to fail.
+type FaultInjector struct {
+	flushPaths map[string]bool
+	flushAll   bool
+	fsyncPaths map[string]bool
+	fsyncAll   bool
+}
+
+// NewFaultInjector parses --flush-error and --fsync-error flag values.
+func NewFaultInjector(flushSpec, fsyncSpec string) *FaultInjector {
+	paths, all := parseFaultSpec(flushSpec)
+	fi := &FaultInjector{flushPaths: paths, flushAll: all}
+	fi.fsyncPaths, fi.fsyncAll = parseFaultSpec(fsyncSpec)
+	return fi
+}
+
+func parseFaultSpec(spec string) (paths map[string]bool, all bool) {
+	if spec == "" {
+		return nil, false
+	}
+	if spec == "*" {
+		return nil, true
+	}
+
+	paths = map[string]bool{}
+	for _, p := range strings.Split(spec, ",") {
+		paths[p] = true
+	}
+	return paths, false
+}
+
+func (fi *FaultInjector) checkFlush(path string) error {
+	if fi.flushAll || fi.flushPaths[path] {
+		return fmt.Errorf("flushobserve: injected flush error for %s", path)
+	}
+	return nil
+}
+
+func (fi *FaultInjector) checkFsync(path string) error {
+	if fi.fsyncAll || fi.fsyncPaths[path] {
+		return fmt.Errorf("flushobserve: injected fsync error for %s", path)
+	}
+	return nil
+}
+
+// Observer is what internal/fs would call on every Flush/Fsync op, once
+// this tree has a FUSE inode layer to call it from: it records a
+// structured Record on the matching pipe and applies fault injection.
+type Observer struct {
+	flushW io.Writer
+	fsyncW io.Writer
+	faults *FaultInjector
+}
+
+// NewObserver builds an Observer writing Flush/Fsync records to flushW and
+// fsyncW respectively (either may be nil to skip recording that op) and
+// applying faults.
+func NewObserver(flushW, fsyncW io.Writer, faults *FaultInjector) *Observer {
+	if faults == nil {
+		faults = NewFaultInjector("", "")
+	}
+	return &Observer{flushW: flushW, fsyncW: fsyncW, faults: faults}
+}
+
+// Flush records a Flush op against inode/path/length and applies
+// --flush-error fault injection.
+func (o *Observer) Flush(inode uint64, path string, length int64) error {
+	if err := o.faults.checkFlush(path); err != nil {
+		return err
+	}
+
+	if o.flushW != nil {
+		if _, err := o.flushW.Write(Record{Op: Flush, Inode: inode, Path: path, Length: length}.Marshal()); err != nil {
+			return fmt.Errorf("flushobserve: writing flush record: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Fsync records an Fsync op against inode/path/length and applies
+// --fsync-error fault injection.
+func (o *Observer) Fsync(inode uint64, path string, length int64) error {
+	if err := o.faults.checkFsync(path); err != nil {
+		return err
+	}
+
+	if o.fsyncW != nil {
+		if _, err := o.fsyncW.Write(Record{Op: Fsync, Inode: inode, Path: path, Length: length}.Marshal()); err != nil {
+			return fmt.Errorf("flushobserve: writing fsync record: %w", err)
+		}
+	}
+
+	return nil
+}
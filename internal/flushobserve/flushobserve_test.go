@@ -0,0 +1,83 @@
+package flushobserve
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRecordMarshalParseRoundTrip(t *testing.T) {
+	r := Record{Op: Fsync, Inode: 7, Path: "/foo/bar", Length: 123}
+
+	got, err := ParseRecord(string(r.Marshal()))
+	if err != nil {
+		t.Fatalf("ParseRecord: %v", err)
+	}
+	if got != r {
+		t.Fatalf("ParseRecord(Marshal()) = %+v, want %+v", got, r)
+	}
+}
+
+func TestObserverWritesRecordsToTheRightPipe(t *testing.T) {
+	var flushBuf, fsyncBuf bytes.Buffer
+	o := NewObserver(&flushBuf, &fsyncBuf, nil)
+
+	if err := o.Flush(2, "/foo", 4); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if fsyncBuf.Len() != 0 {
+		t.Fatalf("Flush wrote to the fsync pipe too: %q", fsyncBuf.String())
+	}
+	if flushBuf.Len() == 0 {
+		t.Fatalf("Flush wrote nothing to the flush pipe")
+	}
+
+	if err := o.Fsync(3, "/bar", 9); err != nil {
+		t.Fatalf("Fsync: %v", err)
+	}
+
+	flushRecord, err := ParseRecord(flushBuf.String())
+	if err != nil {
+		t.Fatalf("ParseRecord(flush): %v", err)
+	}
+	if flushRecord != (Record{Op: Flush, Inode: 2, Path: "/foo", Length: 4}) {
+		t.Fatalf("flush record = %+v", flushRecord)
+	}
+
+	fsyncRecord, err := ParseRecord(fsyncBuf.String())
+	if err != nil {
+		t.Fatalf("ParseRecord(fsync): %v", err)
+	}
+	if fsyncRecord != (Record{Op: Fsync, Inode: 3, Path: "/bar", Length: 9}) {
+		t.Fatalf("fsync record = %+v", fsyncRecord)
+	}
+}
+
+func TestFaultInjectionSkipsTheRecord(t *testing.T) {
+	var flushBuf bytes.Buffer
+	faults := NewFaultInjector("/broken", "")
+	o := NewObserver(&flushBuf, nil, faults)
+
+	if err := o.Flush(1, "/ok", 0); err != nil {
+		t.Fatalf("Flush(/ok): unexpected error %v", err)
+	}
+	if flushBuf.Len() == 0 {
+		t.Fatalf("Flush(/ok) should have recorded")
+	}
+
+	flushBuf.Reset()
+	if err := o.Flush(1, "/broken", 0); err == nil {
+		t.Fatalf("Flush(/broken) should have failed")
+	}
+	if flushBuf.Len() != 0 {
+		t.Fatalf("a failed Flush shouldn't still write a record")
+	}
+}
+
+func TestFaultInjectionWildcard(t *testing.T) {
+	faults := NewFaultInjector("", "*")
+	o := NewObserver(nil, nil, faults)
+
+	if err := o.Fsync(1, "/anything", 0); err == nil {
+		t.Fatalf("Fsync should have failed under a wildcard fault spec")
+	}
+}
@@ -0,0 +1,157 @@
+package fsenc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestConfigRoundTrip(t *testing.T) {
+	cfg, masterKey, err := NewConfig([]byte("hunter2"), 4)
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+
+	got, err := cfg.Unlock([]byte("hunter2"))
+	if err != nil {
+		t.Fatalf("Unlock with correct passphrase: %v", err)
+	}
+
+	if !bytes.Equal(got, masterKey) {
+		t.Fatalf("Unlock returned a different key than NewConfig produced")
+	}
+
+	if _, err := cfg.Unlock([]byte("wrong")); err == nil {
+		t.Fatalf("Unlock with wrong passphrase unexpectedly succeeded")
+	}
+}
+
+func TestFileHeaderRoundTrip(t *testing.T) {
+	_, masterKey, err := NewConfig([]byte("hunter2"), 4)
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+
+	hdr, fileKey, err := NewFileHeader(masterKey)
+	if err != nil {
+		t.Fatalf("NewFileHeader: %v", err)
+	}
+
+	marshaled := hdr.Marshal()
+	if len(marshaled) != HeaderSize {
+		t.Fatalf("Marshal: got %d bytes, want %d", len(marshaled), HeaderSize)
+	}
+
+	parsed, rest, err := UnmarshalFileHeader(marshaled)
+	if err != nil {
+		t.Fatalf("UnmarshalFileHeader: %v", err)
+	}
+	if len(rest) != 0 {
+		t.Fatalf("UnmarshalFileHeader left %d trailing bytes", len(rest))
+	}
+
+	got, err := parsed.UnwrapFileKey(masterKey)
+	if err != nil {
+		t.Fatalf("UnwrapFileKey: %v", err)
+	}
+
+	if !bytes.Equal(got, fileKey) {
+		t.Fatalf("UnwrapFileKey returned a different key than NewFileHeader produced")
+	}
+}
+
+func TestContentsRoundTripAcrossChunkBoundary(t *testing.T) {
+	_, masterKey, err := NewConfig([]byte("hunter2"), 4)
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+
+	_, fileKey, err := NewFileHeader(masterKey)
+	if err != nil {
+		t.Fatalf("NewFileHeader: %v", err)
+	}
+
+	plaintext := bytes.Repeat([]byte("x"), ChunkPlaintextSize+17)
+
+	ciphertext, err := EncryptContents(fileKey, plaintext)
+	if err != nil {
+		t.Fatalf("EncryptContents: %v", err)
+	}
+
+	if bytes.Contains(ciphertext, []byte("xxxxxxxxxx")) {
+		t.Fatalf("ciphertext contains a long run of plaintext bytes")
+	}
+
+	got, err := DecryptContents(fileKey, ciphertext)
+	if err != nil {
+		t.Fatalf("DecryptContents: %v", err)
+	}
+
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("round trip mismatch: got %d bytes, want %d", len(got), len(plaintext))
+	}
+}
+
+func TestContentsRoundTripEmpty(t *testing.T) {
+	_, fileKey, err := NewFileHeader(append(make([]byte, 0, 32), bytes.Repeat([]byte{1}, 32)...))
+	if err != nil {
+		t.Fatalf("NewFileHeader: %v", err)
+	}
+
+	ciphertext, err := EncryptContents(fileKey, nil)
+	if err != nil {
+		t.Fatalf("EncryptContents: %v", err)
+	}
+
+	got, err := DecryptContents(fileKey, ciphertext)
+	if err != nil {
+		t.Fatalf("DecryptContents: %v", err)
+	}
+
+	if len(got) != 0 {
+		t.Fatalf("expected empty round trip, got %d bytes", len(got))
+	}
+}
+
+func TestNameEncryptionIsDeterministicAndReversible(t *testing.T) {
+	nameKey := bytes.Repeat([]byte{0x42}, 32)
+
+	enc1, err := EncryptName(nameKey, "some/file.txt")
+	if err != nil {
+		t.Fatalf("EncryptName: %v", err)
+	}
+	enc2, err := EncryptName(nameKey, "some/file.txt")
+	if err != nil {
+		t.Fatalf("EncryptName: %v", err)
+	}
+
+	if enc1 != enc2 {
+		t.Fatalf("EncryptName is not deterministic: %q != %q", enc1, enc2)
+	}
+
+	if enc1 == "some/file.txt" {
+		t.Fatalf("EncryptName did not obscure the plaintext name")
+	}
+
+	got, err := DecryptName(nameKey, enc1)
+	if err != nil {
+		t.Fatalf("DecryptName: %v", err)
+	}
+
+	if got != "some/file.txt" {
+		t.Fatalf("DecryptName = %q, want %q", got, "some/file.txt")
+	}
+}
+
+func TestNameDecryptionRejectsTampering(t *testing.T) {
+	nameKey := bytes.Repeat([]byte{0x42}, 32)
+
+	enc, err := EncryptName(nameKey, "taco")
+	if err != nil {
+		t.Fatalf("EncryptName: %v", err)
+	}
+
+	otherKey := bytes.Repeat([]byte{0x99}, 32)
+	if _, err := DecryptName(otherKey, enc); err == nil {
+		t.Fatalf("DecryptName with the wrong key unexpectedly succeeded")
+	}
+}
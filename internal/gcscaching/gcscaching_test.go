@@ -0,0 +1,60 @@
+package gcscaching
+
+import (
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/metrics"
+	"github.com/googlecloudplatform/gcsfuse/internal/wiring"
+)
+
+func TestCachesWithinTTLAndRefetchesAfterExpiry(t *testing.T) {
+	inner := wiring.NewBucket("b")
+	if err := inner.CreateObject("foo", []byte("taco")); err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	registry := metrics.NewRegistry()
+	b := NewFastStatBucket(inner, time.Minute, registry)
+
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return clock }
+
+	if _, err := b.Object("foo"); err != nil {
+		t.Fatalf("Object: %v", err)
+	}
+	if got := b.statMisses.Value(); got != 1 {
+		t.Fatalf("statMisses = %v, want 1 after first call", got)
+	}
+
+	if _, err := b.Object("foo"); err != nil {
+		t.Fatalf("Object: %v", err)
+	}
+	if got := b.statHits.Value(); got != 1 {
+		t.Fatalf("statHits = %v, want 1 after a within-TTL repeat", got)
+	}
+	if got := b.typeHits.Value(); got != 1 {
+		t.Fatalf("typeHits = %v, want 1 after a within-TTL repeat", got)
+	}
+
+	// Mutating through the cache must invalidate it.
+	if err := b.CreateObject("foo", []byte("queso")); err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+	got, err := b.Object("foo")
+	if err != nil || string(got) != "queso" {
+		t.Fatalf("Object after overwrite = %q, %v", got, err)
+	}
+	if got := b.statMisses.Value(); got != 2 {
+		t.Fatalf("statMisses = %v, want 2 after the invalidating overwrite", got)
+	}
+
+	// Past the TTL, even an unmodified entry is a fresh miss.
+	clock = clock.Add(time.Hour)
+	if _, err := b.Object("foo"); err != nil {
+		t.Fatalf("Object: %v", err)
+	}
+	if got := b.statMisses.Value(); got != 3 {
+		t.Fatalf("statMisses = %v, want 3 after TTL expiry", got)
+	}
+}
@@ -0,0 +1,143 @@
+// Package metrics implements a minimal Prometheus text-exposition-format
+// counter registry, so --metrics-addr has something real to serve without
+// depending on the full prometheus client library. internal/metricsbucket,
+// internal/ratelimitbucket, and internal/gcscaching all record into a
+// Registry built here.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Counter is a single, monotonically increasing Prometheus counter.
+type Counter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+// Add increases the counter by delta, which must be non-negative.
+func (c *Counter) Add(delta float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value += delta
+}
+
+// Value returns the counter's current value.
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+// CounterVec is a family of Counters distinguished by a single label, e.g.
+// requests-by-method.
+type CounterVec struct {
+	name, help, label string
+
+	mu       sync.Mutex
+	counters map[string]*Counter
+}
+
+// WithLabelValue returns the Counter for the given label value, creating it
+// (at zero) on first use.
+func (cv *CounterVec) WithLabelValue(value string) *Counter {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	c, ok := cv.counters[value]
+	if !ok {
+		c = &Counter{}
+		cv.counters[value] = c
+	}
+	return c
+}
+
+type namedCounter struct {
+	name, help string
+	counter    *Counter
+}
+
+// Registry collects the Counters and CounterVecs subsystems register into
+// it, and renders their current values in Prometheus text exposition
+// format for /metrics.
+type Registry struct {
+	mu       sync.Mutex
+	counters []*namedCounter
+	vecs     []*CounterVec
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounter registers and returns a new Counter.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters = append(r.counters, &namedCounter{name: name, help: help, counter: c})
+
+	return c
+}
+
+// NewCounterVec registers and returns a new CounterVec, labelled by label.
+func (r *Registry) NewCounterVec(name, help, label string) *CounterVec {
+	cv := &CounterVec{name: name, help: help, label: label, counters: map[string]*Counter{}}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.vecs = append(r.vecs, cv)
+
+	return cv
+}
+
+// WritePrometheus renders every Counter and CounterVec registered so far in
+// Prometheus text exposition format.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, nc := range r.counters {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %v\n", nc.name, nc.help, nc.name, nc.name, nc.counter.Value()); err != nil {
+			return err
+		}
+	}
+
+	for _, cv := range r.vecs {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", cv.name, cv.help, cv.name); err != nil {
+			return err
+		}
+
+		cv.mu.Lock()
+		values := make([]string, 0, len(cv.counters))
+		for v := range cv.counters {
+			values = append(values, v)
+		}
+		sort.Strings(values)
+
+		for _, v := range values {
+			if _, err := fmt.Fprintf(w, "%s{%s=%q} %v\n", cv.name, cv.label, v, cv.counters[v].Value()); err != nil {
+				cv.mu.Unlock()
+				return err
+			}
+		}
+		cv.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Handler serves the registry's current values in Prometheus text
+// exposition format, for mounting at /metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WritePrometheus(w)
+	})
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWritePrometheusRendersCountersAndVecs(t *testing.T) {
+	r := NewRegistry()
+
+	c := r.NewCounter("gcsfuse_things_total", "Number of things.")
+	c.Add(3)
+
+	cv := r.NewCounterVec("gcsfuse_ops_total", "Number of ops, by method.", "method")
+	cv.WithLabelValue("Object").Add(2)
+	cv.WithLabelValue("Object").Add(1)
+	cv.WithLabelValue("CreateObject").Add(1)
+
+	var buf bytes.Buffer
+	if err := r.WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"gcsfuse_things_total 3",
+		`gcsfuse_ops_total{method="CreateObject"} 1`,
+		`gcsfuse_ops_total{method="Object"} 3`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("output missing %q; got:\n%s", want, out)
+		}
+	}
+}
+
+func TestCounterVecCountersAreIndependent(t *testing.T) {
+	r := NewRegistry()
+	cv := r.NewCounterVec("x", "help", "label")
+
+	cv.WithLabelValue("a").Add(1)
+	cv.WithLabelValue("b").Add(5)
+
+	if got := cv.WithLabelValue("a").Value(); got != 1 {
+		t.Fatalf("a = %v, want 1", got)
+	}
+	if got := cv.WithLabelValue("b").Value(); got != 5 {
+		t.Fatalf("b = %v, want 5", got)
+	}
+}
@@ -0,0 +1,63 @@
+// Package metricsbucket wraps a wiring.Bucket, recording per-method
+// request counts and bytes transferred into a metrics.Registry -- the "GCS
+// ops per method, bytes in/out" counters --metrics-addr serves.
+package metricsbucket
+
+import (
+	"github.com/googlecloudplatform/gcsfuse/internal/metrics"
+	"github.com/googlecloudplatform/gcsfuse/internal/wiring"
+)
+
+// Bucket is a wiring.Bucket that records metrics into a metrics.Registry
+// for every call it forwards to inner.
+type Bucket struct {
+	inner wiring.Bucket
+
+	requests *metrics.CounterVec
+	bytesIn  *metrics.Counter
+	bytesOut *metrics.Counter
+}
+
+var _ wiring.Bucket = &Bucket{}
+
+// New returns a Bucket wrapping inner, recording into registry.
+func New(inner wiring.Bucket, registry *metrics.Registry) *Bucket {
+	return &Bucket{
+		inner:    inner,
+		requests: registry.NewCounterVec("gcsfuse_gcs_requests_total", "Number of Bucket calls, by method.", "method"),
+		bytesIn:  registry.NewCounter("gcsfuse_gcs_bytes_in_total", "Bytes written to the bucket via CreateObject."),
+		bytesOut: registry.NewCounter("gcsfuse_gcs_bytes_out_total", "Bytes read from the bucket via Object."),
+	}
+}
+
+func (b *Bucket) Name() string { return b.inner.Name() }
+
+func (b *Bucket) Object(name string) ([]byte, error) {
+	b.requests.WithLabelValue("Object").Add(1)
+
+	contents, err := b.inner.Object(name)
+	if err == nil {
+		b.bytesOut.Add(float64(len(contents)))
+	}
+	return contents, err
+}
+
+func (b *Bucket) CreateObject(name string, contents []byte) error {
+	b.requests.WithLabelValue("CreateObject").Add(1)
+
+	err := b.inner.CreateObject(name, contents)
+	if err == nil {
+		b.bytesIn.Add(float64(len(contents)))
+	}
+	return err
+}
+
+func (b *Bucket) DeleteObject(name string) error {
+	b.requests.WithLabelValue("DeleteObject").Add(1)
+	return b.inner.DeleteObject(name)
+}
+
+func (b *Bucket) ListObjects(prefix string) ([]string, error) {
+	b.requests.WithLabelValue("ListObjects").Add(1)
+	return b.inner.ListObjects(prefix)
+}
@@ -0,0 +1,36 @@
+package metricsbucket
+
+import (
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/metrics"
+	"github.com/googlecloudplatform/gcsfuse/internal/wiring"
+)
+
+func TestRecordsRequestCountsAndBytes(t *testing.T) {
+	registry := metrics.NewRegistry()
+	b := New(wiring.NewBucket("b"), registry)
+
+	if err := b.CreateObject("foo", []byte("taco")); err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+	if _, err := b.Object("foo"); err != nil {
+		t.Fatalf("Object: %v", err)
+	}
+	if _, err := b.Object("foo"); err != nil {
+		t.Fatalf("Object: %v", err)
+	}
+
+	if got := b.requests.WithLabelValue("CreateObject").Value(); got != 1 {
+		t.Fatalf("CreateObject count = %v, want 1", got)
+	}
+	if got := b.requests.WithLabelValue("Object").Value(); got != 2 {
+		t.Fatalf("Object count = %v, want 2", got)
+	}
+	if got := b.bytesIn.Value(); got != 4 {
+		t.Fatalf("bytesIn = %v, want 4", got)
+	}
+	if got := b.bytesOut.Value(); got != 8 {
+		t.Fatalf("bytesOut = %v, want 8", got)
+	}
+}
@@ -0,0 +1,115 @@
+// Package pointerbucket implements the --pointer-threshold/--pointer-store
+// mount mode as a wiring.Bucket decorator (cf. internal/encbucket, which
+// uses the same "wrap the bucket" extension point for --encrypt): objects
+// at or above the threshold are hashed, uploaded to a separate
+// content-addressed store bucket, and replaced in the primary bucket with a
+// small git-lfs-style pointer object. Objects below the threshold pass
+// through untouched.
+package pointerbucket
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/wiring"
+)
+
+// pointerVersion is the version line of the git-lfs pointer format this
+// package reads and writes. cf. https://github.com/git-lfs/git-lfs/blob/main/docs/spec.md
+const pointerVersion = "version https://github.com/git-lfs/git-lfs/spec/v1"
+
+// Bucket transparently offloads large objects to a content-addressed store
+// on top of an inner (primary) wiring.Bucket.
+type Bucket struct {
+	inner     wiring.Bucket
+	store     wiring.Bucket
+	threshold int
+}
+
+// New returns a Bucket that stores objects of inner directly if they're
+// smaller than threshold, and otherwise as a pointer object in inner
+// alongside the real contents, content-addressed by SHA-256, in store.
+func New(inner, store wiring.Bucket, threshold int) *Bucket {
+	return &Bucket{inner: inner, store: store, threshold: threshold}
+}
+
+func (b *Bucket) Name() string { return b.inner.Name() }
+
+func (b *Bucket) Object(name string) ([]byte, error) {
+	raw, err := b.inner.Object(name)
+	if err != nil {
+		return nil, err
+	}
+
+	oid, ok := parsePointer(raw)
+	if !ok {
+		// Not a pointer (or it predates this mode) -- return as-is.
+		return raw, nil
+	}
+
+	contents, err := b.store.Object(contentObjectName(oid))
+	if err != nil {
+		return nil, fmt.Errorf("pointerbucket: %s: dereferencing pointer to %s: %w", name, oid, err)
+	}
+
+	return contents, nil
+}
+
+func (b *Bucket) CreateObject(name string, contents []byte) error {
+	if len(contents) < b.threshold {
+		return b.inner.CreateObject(name, contents)
+	}
+
+	sum := sha256.Sum256(contents)
+	oid := hex.EncodeToString(sum[:])
+
+	if err := b.store.CreateObject(contentObjectName(oid), contents); err != nil {
+		return fmt.Errorf("pointerbucket: %s: uploading to content store: %w", name, err)
+	}
+
+	return b.inner.CreateObject(name, marshalPointer(oid, len(contents)))
+}
+
+func (b *Bucket) DeleteObject(name string) error {
+	// Deliberately leave the content-store blob in place: it may be
+	// referenced by other pointers (e.g. identical file contents written
+	// under two names), and content-addressed stores are expected to be
+	// garbage collected out of band rather than on every dereferencing
+	// object's deletion.
+	return b.inner.DeleteObject(name)
+}
+
+func (b *Bucket) ListObjects(prefix string) ([]string, error) {
+	return b.inner.ListObjects(prefix)
+}
+
+func contentObjectName(oid string) string {
+	return "sha256/" + oid
+}
+
+func marshalPointer(oid string, size int) []byte {
+	return []byte(fmt.Sprintf("%s\noid sha256:%s\nsize %d\n", pointerVersion, oid, size))
+}
+
+// parsePointer reports whether raw is a pointer object written by
+// marshalPointer, returning its content hash if so.
+func parsePointer(raw []byte) (oid string, ok bool) {
+	lines := strings.Split(string(raw), "\n")
+	if len(lines) < 3 || lines[0] != pointerVersion {
+		return "", false
+	}
+
+	oidLine := strings.TrimPrefix(lines[1], "oid sha256:")
+	if oidLine == lines[1] || oidLine == "" {
+		return "", false
+	}
+
+	if _, err := strconv.Atoi(strings.TrimPrefix(lines[2], "size ")); err != nil {
+		return "", false
+	}
+
+	return oidLine, true
+}
@@ -0,0 +1,86 @@
+package pointerbucket
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/wiring"
+)
+
+func TestSmallObjectsPassThroughUnmodified(t *testing.T) {
+	primary := wiring.NewBucket("primary")
+	store := wiring.NewBucket("store")
+	b := New(primary, store, 1024)
+
+	if err := b.CreateObject("small.txt", []byte("taco")); err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	raw, err := primary.Object("small.txt")
+	if err != nil {
+		t.Fatalf("primary.Object: %v", err)
+	}
+	if string(raw) != "taco" {
+		t.Fatalf("primary holds %q, want the contents unmodified", raw)
+	}
+
+	names, err := store.ListObjects("")
+	if err != nil {
+		t.Fatalf("store.ListObjects: %v", err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("content store unexpectedly has objects: %v", names)
+	}
+}
+
+func TestLargeObjectBecomesAPointer(t *testing.T) {
+	primary := wiring.NewBucket("primary")
+	store := wiring.NewBucket("store")
+	b := New(primary, store, 4)
+
+	contents := bytes.Repeat([]byte("x"), 4096)
+	if err := b.CreateObject("big.bin", contents); err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	raw, err := primary.Object("big.bin")
+	if err != nil {
+		t.Fatalf("primary.Object: %v", err)
+	}
+	if len(raw) >= len(contents) {
+		t.Fatalf("primary object is %d bytes, expected a small pointer rather than the full %d-byte payload", len(raw), len(contents))
+	}
+	if !strings.HasPrefix(string(raw), pointerVersion) {
+		t.Fatalf("primary object doesn't look like a pointer: %q", raw)
+	}
+
+	got, err := b.Object("big.bin")
+	if err != nil {
+		t.Fatalf("Object (dereferencing): %v", err)
+	}
+	if !bytes.Equal(got, contents) {
+		t.Fatalf("dereferenced contents don't match what was written")
+	}
+}
+
+func TestFreshMountDereferencesPointer(t *testing.T) {
+	primary := wiring.NewBucket("primary")
+	store := wiring.NewBucket("store")
+
+	contents := bytes.Repeat([]byte("y"), 1000)
+	if err := New(primary, store, 4).CreateObject("big.bin", contents); err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	// Simulate a fresh mount: a brand new Bucket value wrapping the same
+	// underlying primary/store buckets.
+	b2 := New(primary, store, 4)
+	got, err := b2.Object("big.bin")
+	if err != nil {
+		t.Fatalf("Object after remount: %v", err)
+	}
+	if !bytes.Equal(got, contents) {
+		t.Fatalf("remount read back different contents")
+	}
+}
@@ -0,0 +1,85 @@
+// Package ratelimitbucket paces wiring.Bucket calls to a fixed rate (cf.
+// --op-rate-limit-hz), recording however long each call had to wait into a
+// metrics.Registry so --op-rate-limit-hz's effect is observable through
+// --metrics-addr rather than just asserted.
+package ratelimitbucket
+
+import (
+	"sync"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/metrics"
+	"github.com/googlecloudplatform/gcsfuse/internal/wiring"
+)
+
+// Bucket is a wiring.Bucket that paces every call it forwards to inner to
+// at most hz calls per second, recording the wait time imposed into a
+// metrics.Registry.
+type Bucket struct {
+	inner    wiring.Bucket
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+
+	wait *metrics.Counter
+
+	// now and sleep are swapped out in tests so pacing doesn't make the
+	// test suite slow.
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+var _ wiring.Bucket = &Bucket{}
+
+// New returns a Bucket wrapping inner, pacing calls to hz per second and
+// recording wait time into registry.
+func New(inner wiring.Bucket, hz float64, registry *metrics.Registry) *Bucket {
+	return &Bucket{
+		inner:    inner,
+		interval: time.Duration(float64(time.Second) / hz),
+		wait:     registry.NewCounter("gcsfuse_rate_limit_wait_seconds_total", "Total time Bucket calls spent waiting on --op-rate-limit-hz."),
+		now:      time.Now,
+		sleep:    time.Sleep,
+	}
+}
+
+// pace blocks until hz has licensed another call.
+func (b *Bucket) pace() {
+	b.mu.Lock()
+	now := b.now()
+	wait := b.next.Sub(now)
+	if wait < 0 {
+		wait = 0
+		b.next = now
+	}
+	b.next = b.next.Add(b.interval)
+	b.mu.Unlock()
+
+	if wait > 0 {
+		b.sleep(wait)
+		b.wait.Add(wait.Seconds())
+	}
+}
+
+func (b *Bucket) Name() string { return b.inner.Name() }
+
+func (b *Bucket) Object(name string) ([]byte, error) {
+	b.pace()
+	return b.inner.Object(name)
+}
+
+func (b *Bucket) CreateObject(name string, contents []byte) error {
+	b.pace()
+	return b.inner.CreateObject(name, contents)
+}
+
+func (b *Bucket) DeleteObject(name string) error {
+	b.pace()
+	return b.inner.DeleteObject(name)
+}
+
+func (b *Bucket) ListObjects(prefix string) ([]string, error) {
+	b.pace()
+	return b.inner.ListObjects(prefix)
+}
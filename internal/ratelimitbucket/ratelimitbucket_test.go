@@ -0,0 +1,49 @@
+package ratelimitbucket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/metrics"
+	"github.com/googlecloudplatform/gcsfuse/internal/wiring"
+)
+
+func TestPacesCallsAndRecordsWaitTime(t *testing.T) {
+	inner := wiring.NewBucket("b")
+	if err := inner.CreateObject("foo", []byte("taco")); err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	registry := metrics.NewRegistry()
+	b := New(inner, 10 /* hz */, registry)
+
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return clock }
+
+	var slept []time.Duration
+	b.sleep = func(d time.Duration) {
+		slept = append(slept, d)
+		clock = clock.Add(d)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := b.Object("foo"); err != nil {
+			t.Fatalf("Object: %v", err)
+		}
+	}
+
+	// The first call is free; the next two each wait out the 100ms
+	// interval implied by 10hz.
+	if len(slept) != 2 {
+		t.Fatalf("slept %v times, want 2", len(slept))
+	}
+	for _, d := range slept {
+		if d != 100*time.Millisecond {
+			t.Fatalf("slept %v, want 100ms", d)
+		}
+	}
+
+	if got := b.wait.Value(); got != 0.2 {
+		t.Fatalf("wait total = %v, want 0.2", got)
+	}
+}
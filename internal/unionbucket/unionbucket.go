@@ -0,0 +1,226 @@
+// Package unionbucket implements the union mount mode: multiple buckets
+// exposed under one root, either as sibling top-level directories
+// (branch-per-bucket, cf. NewBranch) or as an overlay stack with one
+// writable top layer and any number of read-only lower layers (cf.
+// NewOverlay), in both cases as a single wiring.Bucket so the rest of the
+// mount wiring (caching, rate limiting, --encrypt, --pointer-threshold)
+// composes with it unchanged.
+package unionbucket
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/wiring"
+)
+
+// whiteoutPrefix marks an object in the top overlay layer as recording
+// that the same-named object in a lower layer should be hidden, since
+// lower layers are read-only and can't have the real object deleted out
+// from under them.
+const whiteoutPrefix = ".gcsfuse-whiteout-"
+
+func whiteoutName(name string) string { return whiteoutPrefix + name }
+
+// Bucket is a wiring.Bucket backed by more than one underlying bucket,
+// combined per one of the two layouts below.
+type Bucket struct {
+	// Exactly one of (branches) or (top, lowers) is set, selecting the
+	// layout.
+	branches []wiring.Bucket
+
+	top    wiring.Bucket
+	lowers []wiring.Bucket
+}
+
+// NewBranch returns a Bucket exposing each of branches as its own
+// top-level directory named after branches[i].Name(): object name
+// "bucketA/foo" maps to object "foo" in the branch named "bucketA".
+func NewBranch(branches ...wiring.Bucket) *Bucket {
+	return &Bucket{branches: branches}
+}
+
+// NewOverlay returns a Bucket resolving reads against top first and then
+// each of lowers in order, with writes always landing in top and deletes
+// of an object only visible in a lower layer recorded as a whiteout marker
+// in top rather than mutating the (read-only) lower layer.
+func NewOverlay(top wiring.Bucket, lowers ...wiring.Bucket) *Bucket {
+	return &Bucket{top: top, lowers: lowers}
+}
+
+func (b *Bucket) isBranch() bool { return b.branches != nil }
+
+func (b *Bucket) Name() string {
+	if b.isBranch() {
+		return "union"
+	}
+	return b.top.Name()
+}
+
+// route splits a branch-layout object name into the branch it belongs to
+// and the remaining (branch-local) name.
+func (b *Bucket) route(name string) (branch wiring.Bucket, rest string, err error) {
+	branchName, rest, _ := strings.Cut(name, "/")
+
+	for _, br := range b.branches {
+		if br.Name() == branchName {
+			return br, rest, nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("unionbucket: no branch named %q", branchName)
+}
+
+func (b *Bucket) Object(name string) ([]byte, error) {
+	if b.isBranch() {
+		branch, rest, err := b.route(name)
+		if err != nil {
+			return nil, err
+		}
+		return branch.Object(rest)
+	}
+
+	if _, err := b.top.Object(whiteoutName(name)); err == nil {
+		return nil, wiring.NewNotFoundError(name)
+	}
+
+	if contents, err := b.top.Object(name); err == nil {
+		return contents, nil
+	} else if !wiring.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, lo := range b.lowers {
+		if contents, err := lo.Object(name); err == nil {
+			return contents, nil
+		} else if !wiring.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return nil, wiring.NewNotFoundError(name)
+}
+
+func (b *Bucket) CreateObject(name string, contents []byte) error {
+	if b.isBranch() {
+		branch, rest, err := b.route(name)
+		if err != nil {
+			return err
+		}
+		return branch.CreateObject(rest, contents)
+	}
+
+	// A fresh write in the top layer always supersedes any earlier
+	// whiteout of the same name.
+	_ = b.top.DeleteObject(whiteoutName(name))
+	return b.top.CreateObject(name, contents)
+}
+
+func (b *Bucket) DeleteObject(name string) error {
+	if b.isBranch() {
+		branch, rest, err := b.route(name)
+		if err != nil {
+			return err
+		}
+		return branch.DeleteObject(rest)
+	}
+
+	if err := b.top.DeleteObject(name); err != nil {
+		return err
+	}
+
+	visibleInLower := false
+	for _, lo := range b.lowers {
+		if _, err := lo.Object(name); err == nil {
+			visibleInLower = true
+			break
+		}
+	}
+
+	if visibleInLower {
+		return b.top.CreateObject(whiteoutName(name), nil)
+	}
+
+	// Nothing left to hide; clear any stale whiteout from an earlier
+	// delete of the same name.
+	return b.top.DeleteObject(whiteoutName(name))
+}
+
+func (b *Bucket) ListObjects(prefix string) ([]string, error) {
+	if b.isBranch() {
+		return b.listBranch(prefix)
+	}
+	return b.listOverlay(prefix)
+}
+
+func (b *Bucket) listBranch(prefix string) ([]string, error) {
+	if branchName, rest, ok := strings.Cut(prefix, "/"); ok {
+		for _, br := range b.branches {
+			if br.Name() != branchName {
+				continue
+			}
+			names, err := br.ListObjects(rest)
+			if err != nil {
+				return nil, err
+			}
+			out := make([]string, len(names))
+			for i, n := range names {
+				out[i] = branchName + "/" + n
+			}
+			return out, nil
+		}
+		return nil, nil
+	}
+
+	var out []string
+	for _, br := range b.branches {
+		if !strings.HasPrefix(br.Name(), prefix) {
+			continue
+		}
+		names, err := br.ListObjects("")
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range names {
+			out = append(out, br.Name()+"/"+n)
+		}
+	}
+	return out, nil
+}
+
+func (b *Bucket) listOverlay(prefix string) ([]string, error) {
+	whiteouts := map[string]bool{}
+	topNames, err := b.top.ListObjects("")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var out []string
+	for _, n := range topNames {
+		if rest, ok := strings.CutPrefix(n, whiteoutPrefix); ok {
+			whiteouts[rest] = true
+			continue
+		}
+		if strings.HasPrefix(n, prefix) {
+			out = append(out, n)
+		}
+		seen[n] = true
+	}
+
+	for _, lo := range b.lowers {
+		names, err := lo.ListObjects(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range names {
+			if seen[n] || whiteouts[n] {
+				continue
+			}
+			out = append(out, n)
+			seen[n] = true
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,163 @@
+package unionbucket
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/wiring"
+)
+
+func TestBranchLayoutRoutesByTopLevelDirectory(t *testing.T) {
+	a := wiring.NewBucket("a")
+	c := wiring.NewBucket("c")
+	b := NewBranch(a, c)
+
+	if err := b.CreateObject("a/foo", []byte("taco")); err != nil {
+		t.Fatalf("CreateObject(a/foo): %v", err)
+	}
+	if err := b.CreateObject("c/bar", []byte("queso")); err != nil {
+		t.Fatalf("CreateObject(c/bar): %v", err)
+	}
+
+	got, err := a.Object("foo")
+	if err != nil || string(got) != "taco" {
+		t.Fatalf("a.Object(foo) = %q, %v; want taco, nil", got, err)
+	}
+	got, err = c.Object("bar")
+	if err != nil || string(got) != "queso" {
+		t.Fatalf("c.Object(bar) = %q, %v; want queso, nil", got, err)
+	}
+
+	// Each branch should only ever see its own bucket's objects.
+	if _, err := a.Object("bar"); !wiring.IsNotExist(err) {
+		t.Fatalf("a.Object(bar) leaked from branch c: err=%v", err)
+	}
+
+	got, err = b.Object("a/foo")
+	if err != nil || string(got) != "taco" {
+		t.Fatalf("Object(a/foo) = %q, %v", got, err)
+	}
+
+	if err := b.DeleteObject("a/foo"); err != nil {
+		t.Fatalf("DeleteObject(a/foo): %v", err)
+	}
+	if _, err := a.Object("foo"); !wiring.IsNotExist(err) {
+		t.Fatalf("a/foo should be gone after DeleteObject")
+	}
+}
+
+func TestBranchLayoutListObjects(t *testing.T) {
+	a := wiring.NewBucket("a")
+	c := wiring.NewBucket("c")
+	b := NewBranch(a, c)
+
+	for _, name := range []string{"a/foo", "a/sub/bar", "c/baz"} {
+		if err := b.CreateObject(name, []byte("x")); err != nil {
+			t.Fatalf("CreateObject(%q): %v", name, err)
+		}
+	}
+
+	names, err := b.ListObjects("")
+	if err != nil {
+		t.Fatalf("ListObjects: %v", err)
+	}
+	sort.Strings(names)
+
+	want := []string{"a/foo", "a/sub/bar", "c/baz"}
+	if len(names) != len(want) {
+		t.Fatalf("ListObjects(\"\") = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("ListObjects(\"\") = %v, want %v", names, want)
+		}
+	}
+}
+
+func TestOverlayReadsPreferTopLayer(t *testing.T) {
+	top := wiring.NewBucket("top")
+	lower := wiring.NewBucket("lower")
+	b := NewOverlay(top, lower)
+
+	if err := lower.CreateObject("foo", []byte("lower-version")); err != nil {
+		t.Fatalf("lower.CreateObject: %v", err)
+	}
+
+	got, err := b.Object("foo")
+	if err != nil || string(got) != "lower-version" {
+		t.Fatalf("Object(foo) = %q, %v; want the lower layer's content", got, err)
+	}
+
+	if err := b.CreateObject("foo", []byte("top-version")); err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	got, err = b.Object("foo")
+	if err != nil || string(got) != "top-version" {
+		t.Fatalf("Object(foo) = %q, %v; want the top layer's content to win", got, err)
+	}
+
+	lowerRaw, err := lower.Object("foo")
+	if err != nil || string(lowerRaw) != "lower-version" {
+		t.Fatalf("writing through the overlay mutated the read-only lower layer")
+	}
+}
+
+func TestOverlayWritesOnlyLandInTop(t *testing.T) {
+	top := wiring.NewBucket("top")
+	lower := wiring.NewBucket("lower")
+	b := NewOverlay(top, lower)
+
+	if err := b.CreateObject("new.txt", []byte("hi")); err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	if _, err := lower.Object("new.txt"); !wiring.IsNotExist(err) {
+		t.Fatalf("write through the overlay leaked into the lower layer")
+	}
+	if got, err := top.Object("new.txt"); err != nil || string(got) != "hi" {
+		t.Fatalf("top.Object(new.txt) = %q, %v", got, err)
+	}
+}
+
+func TestOverlayDeleteOfLowerOnlyEntryWritesWhiteout(t *testing.T) {
+	top := wiring.NewBucket("top")
+	lower := wiring.NewBucket("lower")
+	b := NewOverlay(top, lower)
+
+	if err := lower.CreateObject("foo", []byte("queso")); err != nil {
+		t.Fatalf("lower.CreateObject: %v", err)
+	}
+
+	if err := b.DeleteObject("foo"); err != nil {
+		t.Fatalf("DeleteObject: %v", err)
+	}
+
+	// The lower layer's object itself must survive (it's read-only)...
+	if got, err := lower.Object("foo"); err != nil || string(got) != "queso" {
+		t.Fatalf("delete through the overlay mutated the read-only lower layer")
+	}
+
+	// ...but the merged view must hide it.
+	if _, err := b.Object("foo"); !wiring.IsNotExist(err) {
+		t.Fatalf("deleted lower-layer entry is still visible through the overlay")
+	}
+
+	names, err := b.ListObjects("")
+	if err != nil {
+		t.Fatalf("ListObjects: %v", err)
+	}
+	for _, n := range names {
+		if n == "foo" {
+			t.Fatalf("ListObjects still reports whited-out entry %q", n)
+		}
+	}
+
+	// Recreating it should clear the whiteout and make it visible again.
+	if err := b.CreateObject("foo", []byte("taco")); err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+	if got, err := b.Object("foo"); err != nil || string(got) != "taco" {
+		t.Fatalf("Object(foo) after recreate = %q, %v", got, err)
+	}
+}
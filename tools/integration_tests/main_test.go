@@ -0,0 +1,49 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+)
+
+// gBuildDir holds a built copy of the gcsfuse binary at bin/gcsfuse, used by
+// GcsfuseTest.SetUp to find t.gcsfusePath.
+var gBuildDir string
+
+func TestMain(m *testing.M) {
+	os.Exit(func() int {
+		dir, err := ioutil.TempDir("", "gcsfuse_build")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "TempDir: %v\n", err)
+			return 1
+		}
+		defer os.RemoveAll(dir)
+
+		binPath := path.Join(dir, "bin/gcsfuse")
+		cmd := exec.Command("go", "build", "-o", binPath, "github.com/googlecloudplatform/gcsfuse/cmd/gcsfuse")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "building gcsfuse: %v\n%s", err, output)
+			return 1
+		}
+
+		gBuildDir = dir
+		return m.Run()
+	}())
+}